@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+)
+
+var configPath = options.String("config", "",
+	"Load flags from this file (one \"name value\" per line); re-read on SIGHUP")
+
+// reloadableFlags are the only flags applied on a SIGHUP reload; every
+// other flag in the file only takes effect on startup. -verbose and
+// -memcache-key are read each time they're used, so a reload changes
+// them right away. -memcache-servers and the -event-sink-* flags are
+// read only once, when the lock backend and the sinks are set up, so a
+// reload after that point does not change the servers or sinks in use.
+var reloadableFlags = map[string]bool{
+	"memcache-servers":   true,
+	"memcache-key":       true,
+	"verbose":            true,
+	"event-sink-webhook": true,
+	"event-sink-statsd":  true,
+	"event-sink-syslog":  true,
+}
+
+// loadConfig applies -config to the flag set, if set. On startup
+// (reload false) every flag in the file is accepted, except ones
+// already given explicitly on the command line, which win. On a
+// SIGHUP reload only reloadableFlags are applied.
+func loadConfig(reload bool) error {
+	if *configPath == "" {
+		return nil
+	}
+
+	explicit := map[string]bool{}
+	if !reload {
+		options.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	}
+
+	f, err := os.Open(*configPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 2)
+		name := fields[0]
+		value := ""
+		if len(fields) == 2 {
+			value = strings.TrimSpace(fields[1])
+		}
+
+		if reload {
+			if !reloadableFlags[name] {
+				Debugf("Ignoring -%s from -config on reload, it only takes effect on startup", name)
+				continue
+			}
+		} else if explicit[name] {
+			continue // flag was given on the command line, it wins
+		}
+
+		if err := options.Set(name, value); err != nil {
+			return fmt.Errorf("config file %s: %s", *configPath, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// watchConfigReload re-parses -config every time sherlock receives
+// SIGHUP, without dropping the held lock or touching the running
+// child.
+func watchConfigReload() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			Debug("Received SIGHUP, reloading -config")
+			if err := loadConfig(true); err != nil {
+				log.Printf("Failed to reload config: %s", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func writeTempConfig(t *testing.T, contents string) string {
+	f, err := ioutil.TempFile("", "sherlock-config-")
+	if err != nil {
+		t.Fatalf("failed to create temp config: %s", err)
+	}
+	if _, err := f.WriteString(contents); err != nil {
+		t.Fatalf("failed to write temp config: %s", err)
+	}
+	f.Close()
+	t.Cleanup(func() { os.Remove(f.Name()) })
+	return f.Name()
+}
+
+func TestLoadConfigExplicitFlagWinsOnStartup(t *testing.T) {
+	options.Parse([]string{"-memcache-key", "cli-value"})
+	*configPath = writeTempConfig(t, "memcache-key file-value\n")
+	defer func() { *configPath = "" }()
+
+	if err := loadConfig(false); err != nil {
+		t.Fatalf("loadConfig() = %s", err)
+	}
+	if *key != "cli-value" {
+		t.Errorf("memcache-key = %q, want %q (command line should win)", *key, "cli-value")
+	}
+}
+
+func TestLoadConfigReloadOnlyAppliesReloadableFlags(t *testing.T) {
+	options.Parse(nil)
+	*configPath = writeTempConfig(t, "memcache-key reloaded-value\nlock-backend redis\n")
+	defer func() { *configPath = "" }()
+
+	if err := loadConfig(true); err != nil {
+		t.Fatalf("loadConfig() = %s", err)
+	}
+	if *key != "reloaded-value" {
+		t.Errorf("memcache-key = %q, want %q (reloadable flag should apply)", *key, "reloaded-value")
+	}
+	if LockBackend() != "memcache" {
+		t.Errorf("LockBackend() = %q, want %q (non-reloadable flag shouldn't change on reload)", LockBackend(), "memcache")
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+)
+
+// Event is a structured record of something that happened during a
+// run of sherlock, e.g. an attempt to acquire the lock or the
+// wrapped program exiting. Its JSON encoding is what ends up in the
+// log file and on the webhook and syslog sinks; the statsd sink only
+// counts events by Type.
+type Event struct {
+	Type   string                 `json:"event"`
+	Time   time.Time              `json:"time"`
+	Fields map[string]interface{} `json:"fields,omitempty"`
+}
+
+// Bus lets interested parties subscribe to the Events sherlock
+// publishes during a run, so sinks can forward them elsewhere
+// without the publisher knowing they exist.
+type Bus interface {
+	Publish(Event)
+	Subscribe() chan Event
+	Unsubscribe(chan Event)
+}
+
+type eventBus struct {
+	mu   sync.Mutex
+	subs map[chan Event]struct{}
+}
+
+// NewBus creates an empty, ready to use Bus.
+func NewBus() Bus {
+	return &eventBus{subs: make(map[chan Event]struct{})}
+}
+
+func (b *eventBus) Subscribe() chan Event {
+	ch := make(chan Event, 16)
+	b.mu.Lock()
+	b.subs[ch] = struct{}{}
+	b.mu.Unlock()
+	return ch
+}
+
+func (b *eventBus) Unsubscribe(ch chan Event) {
+	b.mu.Lock()
+	delete(b.subs, ch)
+	b.mu.Unlock()
+	close(ch)
+}
+
+func (b *eventBus) Publish(e Event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subs {
+		select {
+		case ch <- e:
+		default:
+			// subscriber isn't keeping up, drop the event rather
+			// than block the run
+		}
+	}
+}
+
+// bus is the process-wide event bus; sinks subscribe to it in run().
+var bus = NewBus()
+
+// logEvent records a structured JSON event for eventType and
+// publishes it on bus for any configured -event-sink-* sinks.
+func logEvent(eventType string, fields map[string]interface{}) {
+	ev := Event{Type: eventType, Time: time.Now(), Fields: fields}
+	if b, err := json.Marshal(ev); err == nil {
+		log.Print(string(b))
+	}
+	bus.Publish(ev)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/syslog"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	eventSinkWebhook = options.String("event-sink-webhook", "",
+		"POST each event as JSON to this URL")
+	eventSinkStatsd = options.String("event-sink-statsd", "",
+		"Send event counters to this statsd address (host:port)")
+	eventSinkSyslog = options.String("event-sink-syslog", "",
+		"Forward events to this syslog address (host:port), over UDP")
+)
+
+// startEventSinks subscribes every configured -event-sink-* sink to
+// the event bus. It returns a function that unsubscribes them all and
+// waits for each sink's in-flight delivery to finish, so the final
+// process_exited event isn't dropped by os.Exit racing the sink
+// goroutines; call it before sherlock exits.
+func startEventSinks() func() {
+	var (
+		wg    sync.WaitGroup
+		stops []func()
+	)
+
+	if *eventSinkWebhook != "" {
+		stops = append(stops, subscribeSink(&wg, webhookSink(*eventSinkWebhook)))
+	}
+	if *eventSinkStatsd != "" {
+		stops = append(stops, subscribeSink(&wg, statsdSink(*eventSinkStatsd)))
+	}
+	if *eventSinkSyslog != "" {
+		stops = append(stops, subscribeSink(&wg, syslogSink(*eventSinkSyslog)))
+	}
+
+	return func() {
+		for _, stop := range stops {
+			stop()
+		}
+		wg.Wait()
+	}
+}
+
+// subscribeSink runs send for every event published on the bus until
+// the returned function is called to unsubscribe. It adds to wg
+// before starting so the caller can wait for send to finish draining
+// and return, including whatever event was in flight when the
+// process started shutting down.
+func subscribeSink(wg *sync.WaitGroup, send func(Event)) func() {
+	ch := bus.Subscribe()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for ev := range ch {
+			send(ev)
+		}
+	}()
+	return func() { bus.Unsubscribe(ch) }
+}
+
+// webhookSink POSTs each event as a JSON body to url.
+func webhookSink(url string) func(Event) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	return func(ev Event) {
+		body, err := json.Marshal(ev)
+		if err != nil {
+			return
+		}
+		resp, err := client.Post(url, "application/json", bytes.NewReader(body))
+		if err != nil {
+			log.Printf("event-sink-webhook: %s", err)
+			return
+		}
+		resp.Body.Close()
+	}
+}
+
+// statsdSink increments a counter named sherlock.<event> for every
+// event, using the plain statsd line protocol over UDP.
+func statsdSink(addr string) func(Event) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		log.Printf("event-sink-statsd: %s", err)
+		return func(Event) {}
+	}
+	return func(ev Event) {
+		fmt.Fprintf(conn, "sherlock.%s:1|c\n", ev.Type)
+	}
+}
+
+// syslogSink forwards each event, JSON-encoded, as a syslog message.
+func syslogSink(addr string) func(Event) {
+	w, err := syslog.Dial("udp", addr, syslog.LOG_INFO|syslog.LOG_DAEMON, "sherlock")
+	if err != nil {
+		log.Printf("event-sink-syslog: %s", err)
+		return func(Event) {}
+	}
+	return func(ev Event) {
+		body, err := json.Marshal(ev)
+		if err != nil {
+			return
+		}
+		w.Info(string(body))
+	}
+}
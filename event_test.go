@@ -0,0 +1,48 @@
+package main
+
+import "testing"
+
+func TestEventBusDeliversToSubscribers(t *testing.T) {
+	b := NewBus()
+	ch := b.Subscribe()
+	defer b.Unsubscribe(ch)
+
+	b.Publish(Event{Type: "acquired"})
+
+	select {
+	case ev := <-ch:
+		if ev.Type != "acquired" {
+			t.Errorf("Type = %q, want %q", ev.Type, "acquired")
+		}
+	default:
+		t.Error("subscriber didn't receive the published event")
+	}
+}
+
+func TestEventBusDropsWhenSubscriberIsFull(t *testing.T) {
+	b := NewBus()
+	ch := b.Subscribe()
+	defer b.Unsubscribe(ch)
+
+	// fill the subscriber's buffer, then publish one more: Publish must
+	// not block even though nobody is draining ch.
+	for i := 0; i < cap(ch)+1; i++ {
+		b.Publish(Event{Type: "event"})
+	}
+
+	if got := len(ch); got != cap(ch) {
+		t.Errorf("len(ch) = %d, want %d (buffer full, extra event dropped)", got, cap(ch))
+	}
+}
+
+func TestEventBusUnsubscribeStopsDelivery(t *testing.T) {
+	b := NewBus()
+	ch := b.Subscribe()
+	b.Unsubscribe(ch)
+
+	b.Publish(Event{Type: "acquired"})
+
+	if _, ok := <-ch; ok {
+		t.Error("expected ch to be closed after Unsubscribe")
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+var (
+	lockBackendFlag = options.String("lock-backend", "memcache",
+		"Lock backend to use: memcache|redis|etcd|consul|flock")
+	lockTTLFlag = options.Duration("lock-ttl", 5*time.Minute,
+		"How long the lock is valid for before it must be refreshed (0 disables the periodic refresh)")
+	stealAfterFlag = options.Duration("steal-after", 0,
+		"Forcibly take over a lock once its owner looks dead or older than this (0 disables stealing)")
+	acquireTimeoutFlag = options.Duration("acquire-timeout", 0,
+		"Give up waiting to acquire the lock after this long (0 waits forever, subject to -once)")
+)
+
+// Locker coordinates exclusive access to Key() across multiple hosts,
+// so only one of them runs the wrapped program at a time.
+type Locker interface {
+	// Acquire blocks until the lock is obtained or ctx is done. It
+	// returns ErrDuplicateAcquire if -once is set and the lock is
+	// already held by somebody else, or ctx.Err() if ctx expires
+	// first.
+	Acquire(ctx context.Context) error
+
+	// Release gives up the lock.
+	Release()
+
+	// Refresh renews the lock before it expires. It's called
+	// periodically in the background for as long as the lock
+	// is held, so it must be safe to call repeatedly.
+	Refresh() error
+}
+
+// LockBackend returns the backend selected with -lock-backend.
+func LockBackend() string {
+	return *lockBackendFlag
+}
+
+// LockTTL returns how long a lock may go without being refreshed
+// before it's considered expired.
+func LockTTL() time.Duration {
+	return *lockTTLFlag
+}
+
+// StealAfter returns how old (or how clearly dead) a held lock has
+// to be before a waiter is allowed to forcibly take it over. Zero
+// means stealing is disabled and Acquire waits indefinitely.
+func StealAfter() time.Duration {
+	return *stealAfterFlag
+}
+
+// AcquireTimeout returns how long Acquire is allowed to wait before
+// giving up. Zero means wait forever, subject to -once.
+func AcquireTimeout() time.Duration {
+	return *acquireTimeoutFlag
+}
+
+// acquireWithRetry calls tryAcquire until it reports success, ctx is
+// done, or retrying is disabled by -once. It's the retry loop shared
+// by the backends that poll for the lock (memcache, redis, flock);
+// it backs off exponentially with jitter between attempts so a
+// thundering herd of cron hosts doesn't hammer the backend in
+// lockstep.
+func acquireWithRetry(ctx context.Context, tryAcquire func() (bool, error)) error {
+	for attempt := 0; ; attempt++ {
+		ok, err := tryAcquire()
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		if !Retry() {
+			return ErrDuplicateAcquire
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff(attempt)):
+		}
+	}
+}
+
+// backoff returns an exponential delay with jitter for the given
+// (0-based) retry attempt, capped at 5s.
+func backoff(attempt int) time.Duration {
+	const (
+		base     = 100 * time.Millisecond
+		maxDelay = 5 * time.Second
+	)
+	d := base << uint(attempt)
+	if d <= 0 || d > maxDelay {
+		d = maxDelay
+	}
+	return time.Duration(float64(d) * (0.5 + rand.Float64()*0.5))
+}
+
+// NewLocker builds the Locker selected by -lock-backend.
+func NewLocker() (Locker, error) {
+	switch LockBackend() {
+	case "memcache":
+		return NewMemcLock(), nil
+	case "redis":
+		return NewRedisLock(), nil
+	case "etcd":
+		return NewEtcdLock(), nil
+	case "consul":
+		return NewConsulLock(), nil
+	case "flock":
+		return NewFlockLock(), nil
+	default:
+		return nil, fmt.Errorf("unknown -lock-backend %q", LockBackend())
+	}
+}
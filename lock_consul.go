@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+)
+
+var consulServer = options.String("consul-server", "127.0.0.1:8500",
+	"Consul agent address used by -lock-backend=consul")
+
+// ConsulLock implements Locker on top of consul's session/lock API,
+// which holds the lock key alive with a TTL session the agent
+// refreshes in the background. The session's TTL is taken from
+// -lock-ttl, like the etcd backend; -steal-after has no equivalent
+// here either, since consul reclaims an expired session's locks
+// itself once the TTL lapses.
+type ConsulLock struct {
+	client *api.Client
+	lock   *api.Lock
+	stopCh chan struct{}
+}
+
+func NewConsulLock() *ConsulLock {
+	client, err := api.NewClient(&api.Config{Address: *consulServer})
+	if err != nil {
+		log.Fatalf("Failed to connect to consul: %s", err)
+	}
+	return &ConsulLock{client: client}
+}
+
+func (l *ConsulLock) Acquire(ctx context.Context) error {
+	Debug("Acquiring lock")
+
+	opts := &api.LockOptions{Key: "sherlock/" + Key()}
+	if LockTTL() > 0 {
+		opts.SessionTTL = LockTTL().String()
+	}
+	if !Retry() {
+		// don't wait: a single attempt that fails fast if already held
+		opts.LockTryOnce = true
+		opts.LockWaitTime = time.Millisecond
+	}
+	lock, err := l.client.LockOpts(opts)
+	if err != nil {
+		return err
+	}
+	l.lock = lock
+	l.stopCh = make(chan struct{})
+
+	var stopOnce sync.Once
+	stop := func() { stopOnce.Do(func() { close(l.stopCh) }) }
+
+	go func() {
+		<-ctx.Done()
+		stop() // -acquire-timeout expired: stop waiting
+	}()
+
+	held, err := lock.Lock(l.stopCh)
+	if err != nil {
+		return err
+	}
+	if held == nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return ErrDuplicateAcquire
+	}
+	Debug("Acquired")
+	return nil
+}
+
+func (l *ConsulLock) Release() {
+	Debug("Releasing")
+	if l.lock != nil {
+		l.lock.Unlock()
+	}
+}
+
+func (l *ConsulLock) Refresh() error {
+	// the consul agent renews the underlying session TTL in the
+	// background, nothing to do here
+	return nil
+}
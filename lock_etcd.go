@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"log"
+	"strings"
+
+	"go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+var etcdServers = options.String("etcd-servers", "127.0.0.1:2379",
+	"Comma separated list of etcd servers used by -lock-backend=etcd")
+
+// EtcdLock implements Locker on top of etcd's concurrency package,
+// which already handles the session lease and its keep-alive. The
+// session's lease TTL is taken from -lock-ttl, so all backends react
+// to that flag the same way; there's no equivalent of -steal-after
+// here, since an expired etcd lease is reclaimed by etcd itself as
+// soon as it lapses, without needing a waiter to force it.
+type EtcdLock struct {
+	client  *clientv3.Client
+	session *concurrency.Session
+	mutex   *concurrency.Mutex
+}
+
+func NewEtcdLock() *EtcdLock {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints: strings.Split(*etcdServers, ","),
+	})
+	if err != nil {
+		log.Fatalf("Failed to connect to etcd: %s", err)
+	}
+	return &EtcdLock{client: client}
+}
+
+func (l *EtcdLock) Acquire(ctx context.Context) error {
+	Debug("Acquiring lock")
+
+	var opts []concurrency.SessionOption
+	if LockTTL() > 0 {
+		opts = append(opts, concurrency.WithTTL(int(LockTTL().Seconds())))
+	}
+	session, err := concurrency.NewSession(l.client, opts...)
+	if err != nil {
+		return err
+	}
+	l.session = session
+	l.mutex = concurrency.NewMutex(session, "/sherlock/"+Key())
+
+	if !Retry() {
+		if err := l.mutex.TryLock(ctx); err != nil {
+			session.Close()
+			if err == concurrency.ErrLocked {
+				return ErrDuplicateAcquire
+			}
+			return err
+		}
+		Debug("Acquired")
+		return nil
+	}
+
+	if err := l.mutex.Lock(ctx); err != nil {
+		session.Close()
+		return err
+	}
+	Debug("Acquired")
+	return nil
+}
+
+func (l *EtcdLock) Release() {
+	Debug("Releasing")
+	if l.mutex != nil {
+		l.mutex.Unlock(context.Background())
+	}
+	if l.session != nil {
+		l.session.Close()
+	}
+}
+
+func (l *EtcdLock) Refresh() error {
+	// the session's keep-alive loop already refreshes the
+	// underlying lease in the background
+	return nil
+}
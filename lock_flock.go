@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+
+	"github.com/gofrs/flock"
+)
+
+var lockPath = options.String("lock-path", "/var/run/sherlock.lock",
+	"Path to the lock file used by -lock-backend=flock")
+
+// FlockLock implements Locker with an advisory file lock, so sherlock
+// can coordinate a single host without depending on memcache, redis,
+// etcd or consul being reachable.
+type FlockLock struct {
+	fl *flock.Flock
+}
+
+func NewFlockLock() *FlockLock {
+	return &FlockLock{fl: flock.New(*lockPath)}
+}
+
+func (l *FlockLock) Acquire(ctx context.Context) error {
+	Debug("Acquiring lock")
+	return acquireWithRetry(ctx, func() (bool, error) {
+		locked, err := l.fl.TryLock()
+		if err != nil {
+			return false, err
+		}
+		if locked {
+			Debug("Acquired")
+			return true, nil
+		}
+		Debug("Retrying")
+		return false, nil
+	})
+}
+
+func (l *FlockLock) Release() {
+	Debug("Releasing")
+	l.fl.Unlock()
+}
+
+func (l *FlockLock) Refresh() error {
+	// file locks don't expire, nothing to refresh
+	return nil
+}
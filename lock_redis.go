@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+var redisServer = options.String("redis-server", "127.0.0.1:6379",
+	"Redis server address used by -lock-backend=redis")
+
+// RedisLock implements Locker with a SETNX-style lock, the pattern
+// described in https://redis.io/commands/set#patterns. Like
+// MemcLock, the value holds owner metadata instead of a fixed
+// placeholder, and the key is set with an EX of -lock-ttl (when
+// positive) so it expires if sherlock or its host dies mid-run.
+type RedisLock struct {
+	pool  *redis.Pool
+	owner lockOwner
+}
+
+func NewRedisLock() *RedisLock {
+	return &RedisLock{
+		pool: &redis.Pool{
+			MaxIdle: 1,
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial("tcp", *redisServer)
+			},
+		},
+	}
+}
+
+// setArgs builds the arguments for the SET that takes or refreshes
+// the lock: NX to only take it if absent, XX to only refresh it if
+// the key still exists (whatever value it holds), and EX <seconds> when -lock-ttl is positive (EX 0 is
+// rejected by redis, so a non-positive TTL is left out entirely,
+// meaning the key never expires on its own).
+func (l *RedisLock) setArgs(exists string) []interface{} {
+	args := []interface{}{Key(), l.owner.encode(), exists}
+	if LockTTL() > 0 {
+		args = append(args, "EX", int(LockTTL().Seconds()))
+	}
+	return args
+}
+
+func (l *RedisLock) Acquire(ctx context.Context) error {
+	Debug("Acquiring lock")
+	l.owner = newLockOwner()
+
+	return acquireWithRetry(ctx, func() (bool, error) {
+		// a connection from the pool per attempt: redigo connections
+		// latch their first I/O error permanently, and the pool
+		// discards such a connection on Close instead of handing it
+		// out again, so a single transient error doesn't wedge every
+		// attempt after it
+		conn := l.pool.Get()
+		defer conn.Close()
+
+		reply, err := redis.String(conn.Do("SET", l.setArgs("NX")...))
+		if err == nil && reply == "OK" {
+			Debug("Acquired")
+			return true, nil
+		}
+		if err != nil && err != redis.ErrNil {
+			return false, err
+		}
+
+		if value, getErr := redis.Bytes(conn.Do("GET", Key())); getErr == nil {
+			if holder, decErr := decodeLockOwner(value); decErr == nil {
+				Debugf("Lock held by %s", holder)
+			}
+		}
+
+		Debug("Retrying")
+		return false, nil
+	})
+}
+
+// releaseScript is the standard redis "safe unlock" pattern
+// (https://redis.io/commands/set#patterns): only DEL if the key
+// still holds our own value. Without this check, a lock whose key
+// expired and was taken by another host while we were still
+// running would have its key deleted out from under that host when
+// we finally release, leaving nobody holding it at all.
+var releaseScript = redis.NewScript(1, `
+	if redis.call("GET", KEYS[1]) == ARGV[1] then
+		return redis.call("DEL", KEYS[1])
+	end
+	return 0
+`)
+
+func (l *RedisLock) Release() {
+	Debug("Releasing")
+	conn := l.pool.Get()
+	defer conn.Close()
+	releaseScript.Do(conn, Key(), l.owner.encode())
+}
+
+// Refresh extends the lock's TTL so it doesn't expire while the
+// wrapped program is still running.
+func (l *RedisLock) Refresh() error {
+	conn := l.pool.Get()
+	defer conn.Close()
+	_, err := conn.Do("SET", l.setArgs("XX")...)
+	return err
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// lockOwner is stored as a lock's value, so a contending process (or
+// an operator reading the key by hand) can tell who holds it, since
+// when, and whether it's worth waiting for.
+type lockOwner struct {
+	Hostname string    `json:"hostname"`
+	PID      int       `json:"pid"`
+	Started  time.Time `json:"started"`
+	Nonce    string    `json:"nonce"`
+}
+
+// newLockOwner describes the current process.
+func newLockOwner() lockOwner {
+	hostname, _ := os.Hostname()
+	nonce := make([]byte, 8)
+	rand.Read(nonce)
+	return lockOwner{
+		Hostname: hostname,
+		PID:      os.Getpid(),
+		Started:  time.Now(),
+		Nonce:    fmt.Sprintf("%x", nonce),
+	}
+}
+
+func (o lockOwner) String() string {
+	return fmt.Sprintf("%s[%d] since %s (nonce %s)",
+		o.Hostname, o.PID, o.Started.Format(time.RFC3339), o.Nonce)
+}
+
+func (o lockOwner) encode() []byte {
+	b, err := json.Marshal(o)
+	if err != nil {
+		panic(err) // lockOwner only has marshalable fields
+	}
+	return b
+}
+
+func decodeLockOwner(b []byte) (lockOwner, error) {
+	var o lockOwner
+	err := json.Unmarshal(b, &o)
+	return o, err
+}
+
+// dead reports whether this owner is clearly gone: it ran on this
+// same host and its PID is no longer alive. It can't tell anything
+// about a process on another host, so it returns false in that case
+// and leaves -steal-after to fall back on the owner's age.
+func (o lockOwner) dead() bool {
+	hostname, err := os.Hostname()
+	if err != nil || o.Hostname != hostname {
+		return false
+	}
+	proc, err := os.FindProcess(o.PID)
+	if err != nil {
+		return true
+	}
+	// On unix FindProcess always succeeds; sending signal 0 checks
+	// whether the process exists without actually signaling it.
+	// EPERM means it exists but belongs to another user.
+	err = proc.Signal(syscall.Signal(0))
+	return err != nil && !errors.Is(err, syscall.EPERM)
+}
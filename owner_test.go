@@ -0,0 +1,48 @@
+package main
+
+import (
+	"os"
+	"testing"
+)
+
+func TestLockOwnerEncodeDecodeRoundTrip(t *testing.T) {
+	o := newLockOwner()
+
+	decoded, err := decodeLockOwner(o.encode())
+	if err != nil {
+		t.Fatalf("decodeLockOwner() = %s", err)
+	}
+	// time.Time carries a monotonic reading that JSON can't round-trip,
+	// so compare it with Equal() rather than ==.
+	if decoded.Hostname != o.Hostname || decoded.PID != o.PID || decoded.Nonce != o.Nonce || !decoded.Started.Equal(o.Started) {
+		t.Errorf("decodeLockOwner(o.encode()) = %+v, want %+v", decoded, o)
+	}
+}
+
+func TestLockOwnerDeadForOwnHostWithGoneProcess(t *testing.T) {
+	o := newLockOwner()
+	o.PID = 1 << 30 // astronomically unlikely to be a live PID
+
+	if !o.dead() {
+		t.Errorf("dead() = false, want true for a PID that can't be running")
+	}
+}
+
+func TestLockOwnerNotDeadForOwnHostWithLiveProcess(t *testing.T) {
+	o := newLockOwner()
+	o.PID = os.Getpid()
+
+	if o.dead() {
+		t.Errorf("dead() = true, want false for our own running process")
+	}
+}
+
+func TestLockOwnerNotDeadForOtherHost(t *testing.T) {
+	o := newLockOwner()
+	o.Hostname = o.Hostname + "-somewhere-else"
+	o.PID = 1 << 30
+
+	if o.dead() {
+		t.Errorf("dead() = true, want false: can't tell if a process on another host is gone")
+	}
+}
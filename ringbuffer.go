@@ -0,0 +1,41 @@
+package main
+
+import "sync"
+
+// ringBuffer keeps only the last size bytes ever written to it, so
+// tailing an arbitrarily large or long-lived stream doesn't grow
+// without bound.
+type ringBuffer struct {
+	mu   sync.Mutex
+	buf  []byte
+	size int
+}
+
+func newRingBuffer(size int) *ringBuffer {
+	return &ringBuffer{size: size}
+}
+
+// Write always succeeds, trimming the oldest bytes once size is
+// exceeded.
+func (r *ringBuffer) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.buf = append(r.buf, p...)
+	if len(r.buf) > r.size {
+		r.buf = r.buf[len(r.buf)-r.size:]
+	}
+	return len(p), nil
+}
+
+func (r *ringBuffer) String() string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return string(r.buf)
+}
+
+func (r *ringBuffer) Len() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.buf)
+}
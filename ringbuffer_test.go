@@ -0,0 +1,33 @@
+package main
+
+import "testing"
+
+func TestRingBufferKeepsOnlyTheTail(t *testing.T) {
+	r := newRingBuffer(4)
+
+	r.Write([]byte("ab"))
+	r.Write([]byte("cdef"))
+
+	if got := r.String(); got != "cdef" {
+		t.Errorf("String() = %q, want %q", got, "cdef")
+	}
+	if got := r.Len(); got != 4 {
+		t.Errorf("Len() = %d, want %d", got, 4)
+	}
+}
+
+func TestRingBufferBoundedAcrossManyWrites(t *testing.T) {
+	r := newRingBuffer(1024)
+
+	chunk := make([]byte, 4096)
+	for i := range chunk {
+		chunk[i] = 'x'
+	}
+	for i := 0; i < 1000; i++ {
+		r.Write(chunk)
+	}
+
+	if got := r.Len(); got != 1024 {
+		t.Errorf("Len() = %d, want %d after writing far more than the buffer size", got, 1024)
+	}
+}
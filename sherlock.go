@@ -23,7 +23,9 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"errors"
 	"flag"
 	"fmt"
@@ -35,6 +37,7 @@ import (
 	"os/signal"
 	"runtime/debug"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
@@ -55,6 +58,29 @@ var (
 // exit status when failed to run subprocess
 const errStatus = 25
 
+// exit status when the wrapped program was killed for running longer
+// than -run-timeout
+const runTimeoutStatus = 26
+
+var (
+	runTimeoutFlag = options.Duration("run-timeout", 0,
+		"Kill the wrapped program if it's still running after this long (0 disables)")
+	gracePeriodFlag = options.Duration("grace-period", 10*time.Second,
+		"How long to wait after SIGTERM before escalating to SIGKILL on -run-timeout")
+)
+
+// RunTimeout returns how long the wrapped program is allowed to run
+// before sherlock kills it. Zero disables the timeout.
+func RunTimeout() time.Duration {
+	return *runTimeoutFlag
+}
+
+// GracePeriod returns how long sherlock waits after SIGTERM before
+// escalating to SIGKILL on -run-timeout.
+func GracePeriod() time.Duration {
+	return *gracePeriodFlag
+}
+
 func init() {
 	options.Usage = Usage
 }
@@ -108,42 +134,110 @@ var (
 	ErrDuplicateAcquire = errors.New("Acquired by somebody else and retry disabled with -once")
 )
 
+// MemcLock implements Locker using memcache's atomic Add, the
+// original (and default) sherlock backend. The lock's value holds
+// owner metadata rather than a fixed placeholder, and it's taken
+// with Expiration set to -lock-ttl instead of 0, so an orphaned lock
+// (sherlock or its host died mid-run) ages out instead of wedging
+// every other host forever.
 type MemcLock struct {
-	memc *memcache.Client
+	memc  *memcache.Client
+	owner lockOwner
 }
 
 func NewMemcLock() *MemcLock {
 	return &MemcLock{memc: memcache.New(Servers()...)}
 }
 
-func (ml *MemcLock) Acquire() error {
+func (ml *MemcLock) Acquire(ctx context.Context) error {
 	Debug("Acquiring lock")
-	for {
+	ml.owner = newLockOwner()
+
+	return acquireWithRetry(ctx, func() (bool, error) {
 		err := ml.memc.Add(&memcache.Item{
 			Key:        Key(),
-			Value:      []byte{'H', 'I'},
-			Expiration: 0,
+			Value:      ml.owner.encode(),
+			Expiration: int32(LockTTL().Seconds()),
 		})
 		if err == nil {
 			Debug("Acquired")
-			return nil
+			return true, nil
 		}
-		if err == memcache.ErrNotStored {
-			if !Retry() {
-				return ErrDuplicateAcquire
-			} else {
-				Debug("Retrying")
-				time.Sleep(100 * time.Millisecond)
-			}
-		} else {
-			return err
+		if err != memcache.ErrNotStored {
+			return false, err
 		}
+
+		if ml.tryStealing() {
+			Debug("Acquired (stole expired lock)")
+			return true, nil
+		}
+
+		Debug("Retrying")
+		return false, nil
+	})
+}
+
+// tryStealing looks at whoever currently holds the lock and, if
+// -steal-after is set and that owner looks dead or older than
+// -steal-after, CAS-replaces the key with ours. Returns whether it
+// succeeded.
+func (ml *MemcLock) tryStealing() bool {
+	if StealAfter() == 0 {
+		return false
 	}
+
+	item, err := ml.memc.Get(Key())
+	if err != nil {
+		return false
+	}
+	holder, err := decodeLockOwner(item.Value)
+	if err != nil {
+		Debugf("Lock held by somebody not using owner metadata: %q", item.Value)
+		return false
+	}
+	Debugf("Lock held by %s", holder)
+
+	if !holder.dead() && time.Since(holder.Started) < StealAfter() {
+		return false
+	}
+
+	item.Value = ml.owner.encode()
+	item.Expiration = int32(LockTTL().Seconds())
+	return ml.memc.CompareAndSwap(item) == nil
 }
 
+// Release gives up the lock, but only if it still holds it: if
+// -steal-after let another host take over while we were still
+// running, a plain Delete here would remove that host's lock instead
+// of our own, leaving nobody holding it at all. It reads the key and
+// only deletes it if the value is still our own owner metadata; the
+// read and the Delete are two separate requests, not one atomic step.
 func (ml *MemcLock) Release() {
 	Debug("Releasing")
-	ml.memc.Delete(Key())
+	item, err := ml.memc.Get(Key())
+	if err != nil {
+		return
+	}
+	if !bytes.Equal(item.Value, ml.owner.encode()) {
+		Debug("Lock was stolen by somebody else, not releasing it")
+		return
+	}
+	if err := ml.memc.Delete(Key()); err != nil && err != memcache.ErrCacheMiss {
+		Debugf("Failed to release lock: %s", err)
+	}
+}
+
+// Refresh extends the lock's TTL so it doesn't expire while the
+// wrapped program is still running. It's called periodically from a
+// background goroutine for as long as the lock is held.
+func (ml *MemcLock) Refresh() error {
+	item, err := ml.memc.Get(Key())
+	if err != nil {
+		return err
+	}
+	item.Value = ml.owner.encode()
+	item.Expiration = int32(LockTTL().Seconds())
+	return ml.memc.CompareAndSwap(item)
 }
 
 // run executes the process and manages it.
@@ -161,6 +255,10 @@ func run(args []string) int {
 		return 0
 	}
 
+	if err := loadConfig(false); err != nil {
+		log.Fatal(err)
+	}
+
 	if Logfile() != "stdout" {
 		out, err := os.OpenFile(Logfile(), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0666)
 		if err != nil {
@@ -170,6 +268,9 @@ func run(args []string) int {
 		defer out.Close()
 	}
 
+	// keep a tail of recent log lines for the -status-addr endpoint
+	log.SetOutput(io.MultiWriter(log.Writer(), statusSrv.logs))
+
 	// has to be after log setup otherwise logfile will be closed
 	defer func() {
 		if e := recover(); e != nil {
@@ -177,6 +278,9 @@ func run(args []string) int {
 		}
 	}()
 
+	watchConfigReload()
+	startStatusServer()
+
 	// options.Args() are the arguments after all sherlock flags are
 	// parsed, which means: the program to run
 	programArgs := options.Args()
@@ -185,43 +289,146 @@ func run(args []string) int {
 	}
 	log.Printf("Running %v", programArgs)
 
-	mutex := NewMemcLock()
-	err := mutex.Acquire()
+	stopSinks := startEventSinks()
+	defer stopSinks()
+
+	mutex, err := NewLocker()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	logEvent("lock_acquire_attempt", map[string]interface{}{
+		"backend": LockBackend(),
+		"key":     Key(),
+	})
+
+	acquireCtx := context.Background()
+	if AcquireTimeout() > 0 {
+		var cancel context.CancelFunc
+		acquireCtx, cancel = context.WithTimeout(acquireCtx, AcquireTimeout())
+		defer cancel()
+	}
+
+	err = mutex.Acquire(acquireCtx)
 	if err != nil {
 		if err == ErrDuplicateAcquire {
-			log.Print(err)
+			logEvent("lock_contended", map[string]interface{}{
+				"backend": LockBackend(),
+				"key":     Key(),
+			})
 			return 0
 		}
 		log.Panicf("Failed to acquire lock: %s", err)
 	}
+	logEvent("lock_acquired", map[string]interface{}{
+		"backend": LockBackend(),
+		"key":     Key(),
+	})
+	statusSrv.setLockHeld(true)
+	defer statusSrv.setLockHeld(false)
 	defer mutex.Release()
 
+	refreshDone := make(chan struct{})
+	defer close(refreshDone)
+	go refreshLock(mutex, refreshDone)
+
 	// sherlock will listen to some signals and forward them
 	// to underlying process
 	signals := watchSignals()
 
+	started := time.Now()
 	proc, err := newProcess(programArgs)
 	if err != nil {
 		log.Printf("Failed to start process: %s", err)
 		return errStatus
 	}
+	logEvent("process_started", map[string]interface{}{
+		"pid":  proc.cmd.Process.Pid,
+		"args": programArgs,
+	})
+	statusSrv.setChildPID(proc.cmd.Process.Pid)
+
+	var runTimeout <-chan time.Time
+	if RunTimeout() > 0 {
+		t := time.NewTimer(RunTimeout())
+		defer t.Stop()
+		runTimeout = t.C
+	}
+
+	var killTimer *time.Timer
+	var killTimeout <-chan time.Time
+	timedOut := false
 
 	for {
 		select {
 		case sig := <-signals:
 			Debugf("Received signal: %v. Forwarding to process", sig)
 			proc.Signal(sig)
+			logEvent("signal_forwarded", map[string]interface{}{
+				"signal": sig.String(),
+			})
+		case <-runTimeout:
+			log.Printf("Run timed out after %s, sending SIGTERM to process group", RunTimeout())
+			timedOut = true
+			runTimeout = nil
+			proc.SignalGroup(syscall.SIGTERM)
+			killTimer = time.NewTimer(GracePeriod())
+			killTimeout = killTimer.C
+		case <-killTimeout:
+			log.Printf("Process didn't exit within -grace-period, sending SIGKILL")
+			killTimeout = nil
+			proc.SignalGroup(syscall.SIGKILL)
 		case <-proc.Wait():
+			if killTimer != nil {
+				killTimer.Stop()
+			}
 			if proc.err != nil {
 				log.Printf("Process execution failed: %s", proc.err)
 			}
 			log.Printf("Program stdout:\n%s", proc.stdout)
 			log.Printf("Program stderr:\n%s", proc.stderr)
-			Debugf("Program exited with status code: %d", proc.status)
+			logEvent("process_exited", map[string]interface{}{
+				"status":       proc.status,
+				"duration":     time.Since(started).String(),
+				"stdout_bytes": proc.stdout.Len(),
+				"stderr_bytes": proc.stderr.Len(),
+				"timed_out":    timedOut,
+			})
+			if timedOut {
+				return runTimeoutStatus
+			}
 			return proc.status
 		}
 	}
-	return 0 // will not happen
+}
+
+// refreshLock periodically calls mutex.Refresh() so the lock doesn't
+// expire while the wrapped program is still running. It stops as
+// soon as done is closed.
+//
+// A -lock-ttl of 0 (or anything too small to divide into a positive
+// interval) means refreshing is disabled, same as -run-timeout and
+// -acquire-timeout: there's nothing to refresh against, and
+// time.NewTicker panics on a non-positive interval.
+func refreshLock(mutex Locker, done <-chan struct{}) {
+	interval := LockTTL() / 3
+	if interval <= 0 {
+		<-done
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := mutex.Refresh(); err != nil {
+				log.Printf("Failed to refresh lock: %s", err)
+			}
+		case <-done:
+			return
+		}
+	}
 }
 
 // Starts listening to signals that should quit the process.
@@ -240,6 +447,13 @@ func watchSignals() (sinals chan os.Signal) {
 	return signals
 }
 
+var (
+	passthrough = options.Bool("passthrough", false,
+		"Also forward the child's stdout/stderr to sherlock's own stdout/stderr")
+	tailBytes = options.Int("tail-bytes", 64*1024,
+		"How many trailing bytes of stdout/stderr to keep in memory for the final log lines")
+)
+
 // process wraps a exec.Cmd execution and keeps it's exit
 // status, stdout and stderr
 //
@@ -248,7 +462,7 @@ type process struct {
 	cmd            *exec.Cmd
 	status         int
 	err            error
-	stdout, stderr io.Reader
+	stdout, stderr *ringBuffer
 	finished       chan struct{}
 }
 
@@ -261,26 +475,46 @@ type process struct {
 // SIGINT to all process in that group, and the subprocess is added to the
 // same group by default. I don't want that, I want sherlock to send termination
 // signals to it's subprocess.
+//
+// Stdout and stderr are streamed line by line into the log as they're
+// produced, instead of being buffered up and dumped at the end, so a
+// long-running or chatty child doesn't grow sherlock's memory usage
+// or hide its output until it exits. Only the last -tail-bytes of
+// each stream are kept around, for the final "Program stdout"/"Program
+// stderr" log lines.
 func newProcess(args []string) (*process, error) {
-	var out, err bytes.Buffer
-
 	proc := &process{
 		cmd:      exec.Command(args[0], args[1:]...),
-		stdout:   &out, // process uses as io.Reader
-		stderr:   &err,
+		stdout:   newRingBuffer(*tailBytes),
+		stderr:   newRingBuffer(*tailBytes),
 		finished: make(chan struct{}, 1),
 	}
 	proc.cmd.SysProcAttr = &syscall.SysProcAttr{
 		Setpgid: true, // add process to new group, different than sherlock's
 	}
-	proc.cmd.Stdout = &out // cmd uses as io.Writer
-	proc.cmd.Stderr = &err
+
+	stdout, err := proc.cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr, err := proc.cmd.StderrPipe()
+	if err != nil {
+		return nil, err
+	}
 
 	if err := proc.cmd.Start(); err != nil {
 		return nil, err
 	}
 
+	var streams sync.WaitGroup
+	streams.Add(2)
+	go proc.stream("stdout", stdout, proc.stdout, os.Stdout, &streams)
+	go proc.stream("stderr", stderr, proc.stderr, os.Stderr, &streams)
+
 	go func() {
+		// cmd.Wait() must only be called once both pipes have been
+		// fully read, otherwise it can race with the readers above.
+		streams.Wait()
 		if err := proc.cmd.Wait(); err != nil {
 			proc.err = err
 		}
@@ -291,11 +525,65 @@ func newProcess(args []string) (*process, error) {
 	return proc, nil
 }
 
+// stream copies r into the log, line by line, prefixed with "name=",
+// and keeps a tail of it in buf. When -passthrough is set it also
+// forwards each line to out (sherlock's own stdout or stderr).
+//
+// It reads with bufio.Reader.ReadSlice instead of bufio.Scanner: a
+// Scanner returns ErrTooLong and stops for good on any line longer
+// than its buffer, leaving the rest of that line (and everything
+// after it) stuck unread in the pipe, which can wedge the child
+// forever once the pipe's kernel buffer fills up. ReadSlice instead
+// reports an oversized line as a sequence of fragments, so the
+// stream keeps draining no matter how long a single line gets.
+func (p *process) stream(name string, r io.Reader, buf *ringBuffer, out *os.File, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	reader := bufio.NewReaderSize(r, 64*1024)
+	for {
+		chunk, err := reader.ReadSlice('\n')
+
+		if len(chunk) > 0 {
+			if err == bufio.ErrBufferFull {
+				// not a full line, just as much of an oversized
+				// one as fits in our buffer; log it as-is and
+				// keep reading the rest of it
+				log.Printf("%s=%s", name, chunk)
+				buf.Write(chunk)
+				if *passthrough {
+					out.Write(chunk)
+				}
+				continue
+			}
+
+			line := bytes.TrimSuffix(chunk, []byte{'\n'})
+			log.Printf("%s=%s", name, line)
+			buf.Write(line)
+			buf.Write([]byte{'\n'})
+			if *passthrough {
+				out.Write(chunk)
+			}
+		}
+
+		if err != nil {
+			return // EOF, or the pipe broke
+		}
+	}
+}
+
 // Signal sends a signal to the process
 func (p *process) Signal(sig os.Signal) error {
 	return p.cmd.Process.Signal(sig)
 }
 
+// SignalGroup sends sig to the whole process group sherlock put the
+// child in (see Setpgid above), so any grandchildren it spawned are
+// reached too. A negative pid is how the kill(2) family addresses a
+// process group.
+func (p *process) SignalGroup(sig syscall.Signal) error {
+	return syscall.Kill(-p.cmd.Process.Pid, sig)
+}
+
 // Wait returns a channel where caller should receive from
 // that indicates when the process has finished
 //
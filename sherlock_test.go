@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"runtime"
 	"strings"
 	"testing"
 
@@ -50,6 +51,33 @@ func TestCaptureProcessOutput(t *testing.T) {
 	assertContains(t, output, "Program stderr:\nerror output")
 }
 
+// TestStreamLargeOutputKeepsConstantMemory pipes well over 100MB of
+// child output through sherlock and asserts that doesn't show up as
+// heap growth, i.e. sherlock is streaming it rather than buffering it
+// all up before logging.
+func TestStreamLargeOutputKeepsConstantMemory(t *testing.T) {
+	setup()
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	args := []string{
+		"sherlock", "-logfile", testlog, "-tail-bytes", "4096",
+		"/usr/bin/python", "-c",
+		"import sys\nfor _ in range(150000):\n    sys.stdout.write('x' * 1024 + chr(10))\n",
+	}
+	run(args)
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+
+	grew := int64(after.HeapAlloc) - int64(before.HeapAlloc)
+	if grew > 20*1024*1024 {
+		t.Errorf("heap grew by %d bytes streaming >100MB of child output, wanted it to stay roughly constant", grew)
+	}
+}
+
 func runSherlock(extra []string) {
 	args := []string{"sherlock", "-logfile", testlog, "/usr/bin/python", "sherlock_test_helper.py"}
 	args = append(args, extra...)
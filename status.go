@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+var statusAddr = options.String("status-addr", "",
+	"Listen on this address with an HTTP status endpoint (empty disables)")
+
+// statusServer tracks the state surfaced by -status-addr: whether
+// the lock is currently held, the wrapped program's PID, and a tail
+// of recent log lines.
+type statusServer struct {
+	mu        sync.Mutex
+	startTime time.Time
+	lockHeld  bool
+	childPID  int
+	logs      *ringBuffer
+}
+
+// statusSrv is the process-wide instance; run() updates it as
+// sherlock progresses through acquiring the lock and running the
+// child.
+var statusSrv = &statusServer{logs: newRingBuffer(16 * 1024)}
+
+func (s *statusServer) setLockHeld(held bool) {
+	s.mu.Lock()
+	s.lockHeld = held
+	s.mu.Unlock()
+}
+
+func (s *statusServer) setChildPID(pid int) {
+	s.mu.Lock()
+	s.childPID = pid
+	s.mu.Unlock()
+}
+
+func (s *statusServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Path {
+	case "/healthz":
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok\n"))
+	case "/status":
+		s.mu.Lock()
+		data := map[string]interface{}{
+			"lock_backend": LockBackend(),
+			"lock_key":     Key(),
+			"lock_held":    s.lockHeld,
+			"child_pid":    s.childPID,
+			"uptime":       time.Since(s.startTime).String(),
+			"logs":         strings.Split(strings.TrimRight(s.logs.String(), "\n"), "\n"),
+		}
+		s.mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(data)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// startStatusServer starts the -status-addr HTTP endpoint in the
+// background, if configured. Listen errors are logged but don't stop
+// sherlock, since the status endpoint is optional.
+func startStatusServer() {
+	if *statusAddr == "" {
+		return
+	}
+	statusSrv.startTime = time.Now()
+	go func() {
+		if err := http.ListenAndServe(*statusAddr, statusSrv); err != nil {
+			log.Printf("status server: %s", err)
+		}
+	}()
+}